@@ -2,11 +2,14 @@ package blockchain
 
 import (
 	//"bytes"
-	"log"
 	"reflect"
 	"time"
 )
 
+// MaxBlockTransactions caps how many pending transactions CreateNewBlock
+// will pull from the pool into a single block.
+const MaxBlockTransactions = 1000
+
 type Blockchain struct {
 	CurrentBlock Block
 	// Holds all available blocks
@@ -19,6 +22,10 @@ type Blockchain struct {
 	nq chan Message
 	// Public-Private keypair for this chain
 	Keypair *Keypair
+	// Transactions waiting to be included in a block
+	pool *TxPool
+
+	log Logger
 }
 
 func NewBlockchain(keypair *Keypair, broadcastChan chan Message) *Blockchain {
@@ -27,6 +34,8 @@ func NewBlockchain(keypair *Keypair, broadcastChan chan Message) *Blockchain {
 		bq:      make(chan Block),
 		Keypair: keypair,
 		nq:      broadcastChan,
+		pool:    NewTxPool(defaultTxPoolSize),
+		log:     NewStdLogger(),
 	}
 	// TODO: Read blockchain from file and stuff...
 	bl.CurrentBlock = bl.CreateNewBlock()
@@ -34,6 +43,11 @@ func NewBlockchain(keypair *Keypair, broadcastChan chan Message) *Blockchain {
 	return bl
 }
 
+// SetLogger overrides the blockchain's default logger.
+func (bl *Blockchain) SetLogger(l Logger) {
+	bl.log = l
+}
+
 func (bl *Blockchain) QueueTransaction(tx *Transaction) {
 	bl.tq <- tx
 }
@@ -52,10 +66,26 @@ func (bl *Blockchain) CreateNewBlock() Block {
 
 	b := NewBlock(prevBlockHash)
 	b.BlockHeader.Origin = bl.Keypair.Public
+	b.TransactionSlice = bl.pendingTransactionSlice()
 
 	return b
 }
 
+// pendingTransactionSlice returns the pool's pending transactions, capped
+// to MaxBlockTransactions, ready to go into a new block.
+func (bl *Blockchain) pendingTransactionSlice() *TransactionSlice {
+	pending := bl.pool.Pending()
+	if len(pending) > MaxBlockTransactions {
+		pending = pending[:MaxBlockTransactions]
+	}
+
+	ts := make(TransactionSlice, len(pending))
+	for i, tr := range pending {
+		ts[i] = *tr
+	}
+	return &ts
+}
+
 func (bl *Blockchain) AddBlock(b Block) {
 	bl.BlockSlice = append(bl.BlockSlice, b)
 }
@@ -66,15 +96,16 @@ func (bl *Blockchain) Run() {
 	for {
 		select {
 		case tr := <-bl.tq:
-			if bl.CurrentBlock.TransactionSlice.Exists(*tr) {
+			if !tr.VerifyTransaction(TRANSACTION_POW) {
+				bl.log.Warn("transaction verification failed", "tx", tr)
 				continue
 			}
-			if !tr.VerifyTransaction(TRANSACTION_POW) {
-				log.Println("Transaction verfication failed:", tr)
+			if err := bl.pool.Add(tr); err != nil {
+				bl.log.Warn("transaction rejected", "tx", tr, "err", err)
 				continue
 			}
 
-			bl.CurrentBlock.AddTransaction(tr)
+			bl.CurrentBlock.TransactionSlice = bl.pendingTransactionSlice()
 			interruptBlockGen <- bl.CurrentBlock
 			// Build transaction message
 			mes := NewMessage(MESSAGE_SEND_TRANSACTION)
@@ -85,28 +116,26 @@ func (bl *Blockchain) Run() {
 
 		case b := <-bl.bq:
 			if bl.BlockSlice.Exists(b) {
-				log.Println("Block exists:", b.String())
+				bl.log.Debug("block exists", "block", b.String())
 				continue
 			}
 			if !b.VerifyBlock(BLOCK_POW) {
-				log.Println("Block verification failed:", b.String())
+				bl.log.Warn("block verification failed", "block", b.String())
 				continue
 			}
 
 			if reflect.DeepEqual(b.PrevBlock, bl.CurrentBlock.Hash()) {
 				// I'm missing some blocks in the middle. Request'em.
-				log.Println("Missing blocks in between")
+				bl.log.Warn("missing blocks in between")
 			} else {
-				log.Println("New block:", b.String())
-				transDiff := TransactionSlice{}
-				if !reflect.DeepEqual(b.BlockHeader.MerkelRoot, bl.CurrentBlock.MerkelRoot) {
-					// Transactions are different
-					log.Println("Transactions are different. Finding diff")
-					transDiff = DiffTransactionSlices(*bl.CurrentBlock.TransactionSlice, *b.TransactionSlice)
-				}
+				bl.log.Info("new block", "block", b.String())
 
 				bl.AddBlock(b)
-				log.Println("Chain size:", len(bl.BlockSlice))
+				bl.log.Info("chain size", "size", len(bl.BlockSlice))
+
+				if b.TransactionSlice != nil {
+					bl.pool.Promote(*b.TransactionSlice)
+				}
 
 				//Broadcast block to network
 				mes := NewMessage(MESSAGE_SEND_BLOCK)
@@ -114,7 +143,6 @@ func (bl *Blockchain) Run() {
 				bl.nq <- *mes
 				//New Block
 				bl.CurrentBlock = bl.CreateNewBlock()
-				bl.CurrentBlock.TransactionSlice = &transDiff
 
 				interruptBlockGen <- bl.CurrentBlock
 			}
@@ -129,7 +157,7 @@ func (bl *Blockchain) GenerateBlocks() chan Block {
 		block := <-interrupt
 
 	loop:
-		log.Println("Starting Proof of Work:", block.String())
+		bl.log.Info("starting proof of work", "block", block.String())
 		block.BlockHeader.MerkelRoot = block.GenerateMerkelRoot()
 		block.BlockHeader.Nonce = 0
 		block.BlockHeader.Timestamp = uint32(time.Now().Unix())
@@ -143,14 +171,14 @@ func (bl *Blockchain) GenerateBlocks() chan Block {
 					block.Signature = block.Sign(bl.Keypair)
 					bl.bq <- block
 					sleepTime = time.Hour * 24
-					log.Println("Found Block:", block.String())
+					bl.log.Info("found block", "block", block.String())
 				} else {
 					block.BlockHeader.Nonce += 1
 				}
 
 			} else {
 				sleepTime = time.Hour * 24
-				log.Println("No transactions. Sleeping for", sleepTime.Seconds(), "secs")
+				bl.log.Debug("no transactions, sleeping", "secs", sleepTime.Seconds())
 			}
 
 			select {
@@ -165,24 +193,3 @@ func (bl *Blockchain) GenerateBlocks() chan Block {
 
 	return interrupt
 }
-
-//Assumes transaction arrays are sorted (which maybe is too big of an assumption)
-func DiffTransactionSlices(a, b TransactionSlice) (diff TransactionSlice) {
-	lastj := 0
-	for _, t := range a {
-		found := false
-		for j := lastj; j < len(b); j++ {
-			if reflect.DeepEqual(b[j].Signature, t.Signature) {
-				found = true
-				lastj = j
-				break
-			}
-		}
-
-		if !found {
-			diff = append(diff, t)
-		}
-	}
-
-	return
-}
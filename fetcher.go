@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// fetchBatchWindow is how long the fetcher waits to collect more
+	// announcements for the same host before issuing a GetBlockBodies
+	// request, so a burst of announcements turns into one round trip.
+	fetchBatchWindow = 50 * time.Millisecond
+
+	// fetchTimeout bounds how long a pending body fetch may take before the
+	// unresponsive peer is dropped.
+	fetchTimeout = 10 * time.Second
+)
+
+// announcement is a single block-announce record queued for fetching.
+type announcement struct {
+	host string
+	hash []byte
+}
+
+// fetcher collects block announcements, batches them per host, and pulls
+// the bodies via reqTypeGetBlockBodies, delivering them to the transport's
+// block channel.  Hashes already in flight are deduped so announcements
+// from multiple peers don't trigger duplicate fetches.
+type fetcher struct {
+	ct *ChordTransport
+
+	queue chan announcement
+
+	mu       sync.Mutex
+	inFlight map[string]bool
+}
+
+func newFetcher(ct *ChordTransport) *fetcher {
+	f := &fetcher{
+		ct:       ct,
+		queue:    make(chan announcement, 256),
+		inFlight: map[string]bool{},
+	}
+	go f.run()
+	return f
+}
+
+// Announce queues hash, known to be held by host, for fetching unless it is
+// already in flight.
+func (f *fetcher) Announce(host string, hash []byte) {
+	f.mu.Lock()
+	if f.inFlight[string(hash)] {
+		f.mu.Unlock()
+		return
+	}
+	f.inFlight[string(hash)] = true
+	f.mu.Unlock()
+
+	f.queue <- announcement{host: host, hash: hash}
+}
+
+func (f *fetcher) run() {
+	batches := map[string][][]byte{}
+	timer := time.NewTimer(fetchBatchWindow)
+	defer timer.Stop()
+
+	for {
+		select {
+		case a := <-f.queue:
+			batches[a.host] = append(batches[a.host], a.hash)
+
+		case <-timer.C:
+			for host, hashes := range batches {
+				go f.fetch(host, hashes)
+			}
+			batches = map[string][][]byte{}
+			timer.Reset(fetchBatchWindow)
+		}
+	}
+}
+
+// fetch requests hashes from host and forwards the returned bodies to the
+// block channel, dropping host if the round trip doesn't complete in time.
+func (f *fetcher) fetch(host string, hashes [][]byte) {
+	defer func() {
+		f.mu.Lock()
+		for _, h := range hashes {
+			delete(f.inFlight, string(h))
+		}
+		f.mu.Unlock()
+	}()
+
+	done := make(chan error, 1)
+	var bodies blockBodies
+
+	hdr := f.ct.header(reqTypeGetBlockBodies)
+	go func() {
+		done <- f.ct.doRequest(host, hdr, &getBlockBodiesReq{Hashes: hashes}, &bodies)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			f.ct.log.Error("fetch bodies failed", "peer", host, "reqID", hdr.ReqID, "count", len(hashes), "err", err)
+			return
+		}
+
+	case <-time.After(fetchTimeout):
+		f.ct.log.Warn("fetch timed out, dropping peer", "peer", host, "reqID", hdr.ReqID)
+		f.ct.StopPeer(host)
+		return
+	}
+
+	for _, blk := range bodies.Blocks {
+		if blk.BlockHeader == nil {
+			continue
+		}
+		f.ct.bch <- blk
+	}
+}
@@ -0,0 +1,25 @@
+package blockchain
+
+// HeaderChain is the header-only counterpart to BlockSlice: it holds just
+// the BlockHeaders of a chain, enough for a light client to validate work
+// and request bodies or merkle proofs on demand without storing the full
+// chain.
+type HeaderChain []*BlockHeader
+
+// Len returns the number of headers held.
+func (hc HeaderChain) Len() int {
+	return len(hc)
+}
+
+// Last returns the most recently added header, or nil if the chain is empty.
+func (hc HeaderChain) Last() *BlockHeader {
+	if len(hc) == 0 {
+		return nil
+	}
+	return hc[len(hc)-1]
+}
+
+// Add appends a header to the chain.
+func (hc *HeaderChain) Add(h *BlockHeader) {
+	*hc = append(*hc, h)
+}
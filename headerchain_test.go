@@ -0,0 +1,23 @@
+package blockchain
+
+import "testing"
+
+func TestHeaderChainAddAndLast(t *testing.T) {
+	var hc HeaderChain
+
+	if hc.Last() != nil {
+		t.Fatalf("expected Last of an empty chain to be nil")
+	}
+
+	first := &BlockHeader{Nonce: 1}
+	second := &BlockHeader{Nonce: 2}
+	hc.Add(first)
+	hc.Add(second)
+
+	if n := hc.Len(); n != 2 {
+		t.Fatalf("Len() = %d, want 2", n)
+	}
+	if hc.Last() != second {
+		t.Fatalf("Last() did not return the most recently added header")
+	}
+}
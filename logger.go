@@ -0,0 +1,51 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+)
+
+// Logger is the structured logging interface used throughout the
+// blockchain and networking code.  Each call takes a message and an even
+// number of key/value pairs, go-kit/log style, e.g.:
+//
+//	logger.Error("request failed", "peer", host, "type", typ, "err", err)
+type Logger interface {
+	Debug(msg string, kv ...interface{})
+	Info(msg string, kv ...interface{})
+	Warn(msg string, kv ...interface{})
+	Error(msg string, kv ...interface{})
+}
+
+// stdLogger is the default Logger, backed by the standard library's log
+// package.
+type stdLogger struct {
+	*log.Logger
+}
+
+// NewStdLogger returns a Logger that writes leveled, key/value formatted
+// lines through the standard library logger.
+func NewStdLogger() Logger {
+	return &stdLogger{Logger: log.New(log.Writer(), "", log.LstdFlags)}
+}
+
+// defaultLogger backs package-level helpers that have no instance to carry a
+// Logger field of their own.
+var defaultLogger = NewStdLogger()
+
+func (l *stdLogger) Debug(msg string, kv ...interface{}) { l.log("DBG", msg, kv...) }
+func (l *stdLogger) Info(msg string, kv ...interface{})  { l.log("INF", msg, kv...) }
+func (l *stdLogger) Warn(msg string, kv ...interface{})  { l.log("WRN", msg, kv...) }
+func (l *stdLogger) Error(msg string, kv ...interface{}) { l.log("ERR", msg, kv...) }
+
+func (l *stdLogger) log(level, msg string, kv ...interface{}) {
+	var b bytes.Buffer
+	b.WriteString(level)
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", kv[i], kv[i+1])
+	}
+	l.Logger.Println(b.String())
+}
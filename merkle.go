@@ -0,0 +1,96 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// MerkleProof is an ordered list of sibling hashes, together with the side
+// each sibling sits on, that lets a light client rebuild a block's
+// MerkelRoot from a single transaction hash without holding the rest of the
+// block.
+type MerkleProof struct {
+	TxHash []byte
+	// Siblings are the hashes encountered walking from the leaf to the
+	// root, one per level.
+	Siblings [][]byte
+	// Left reports, for each entry in Siblings, whether that sibling sits
+	// to the left of the hash being folded.
+	Left []bool
+}
+
+// hashPair combines two node hashes the same way Block.GenerateMerkelRoot
+// does when building the tree.
+func hashPair(a, b []byte) []byte {
+	h := sha256.Sum256(append(append([]byte{}, a...), b...))
+	return h[:]
+}
+
+// BuildTxProof builds the merkle branch proving that the transaction with
+// hash txHash is included under block's MerkelRoot.
+func BuildTxProof(block *Block, txHash []byte) (*MerkleProof, error) {
+	if block.TransactionSlice == nil || block.TransactionSlice.Len() == 0 {
+		return nil, fmt.Errorf("block has no transactions")
+	}
+
+	level := make([][]byte, 0, block.TransactionSlice.Len())
+	idx := -1
+	for i, tx := range *block.TransactionSlice {
+		h := tx.Hash()
+		if bytes.Equal(h, txHash) {
+			idx = i
+		}
+		level = append(level, h)
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("transaction not in block: %x", txHash)
+	}
+
+	proof := &MerkleProof{TxHash: txHash}
+
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+
+		next := make([][]byte, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i == idx {
+				proof.Siblings = append(proof.Siblings, level[i+1])
+				proof.Left = append(proof.Left, false)
+				idx = len(next)
+			} else if i+1 == idx {
+				proof.Siblings = append(proof.Siblings, level[i])
+				proof.Left = append(proof.Left, true)
+				idx = len(next)
+			}
+			next = append(next, hashPair(level[i], level[i+1]))
+		}
+		level = next
+	}
+
+	return proof, nil
+}
+
+// VerifyTxProof checks that branch proves txHash is included under header's
+// MerkelRoot, by folding the branch back up to a root and comparing.
+func VerifyTxProof(header *BlockHeader, txHash []byte, branch *MerkleProof) bool {
+	if header == nil || branch == nil || !bytes.Equal(branch.TxHash, txHash) {
+		return false
+	}
+	if len(branch.Siblings) != len(branch.Left) {
+		return false
+	}
+
+	h := txHash
+	for i, sib := range branch.Siblings {
+		if branch.Left[i] {
+			h = hashPair(sib, h)
+		} else {
+			h = hashPair(h, sib)
+		}
+	}
+
+	return bytes.Equal(h, header.MerkelRoot)
+}
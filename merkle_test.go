@@ -0,0 +1,36 @@
+package blockchain
+
+import "testing"
+
+func TestBuildAndVerifyTxProofRoundTrip(t *testing.T) {
+	ts := TransactionSlice{
+		Transaction{Signature: []byte("tx-a")},
+		Transaction{Signature: []byte("tx-b")},
+		Transaction{Signature: []byte("tx-c")},
+	}
+	block := &Block{BlockHeader: &BlockHeader{}, TransactionSlice: &ts}
+	block.MerkelRoot = block.GenerateMerkelRoot()
+
+	target := ts[1].Hash()
+	proof, err := BuildTxProof(block, target)
+	if err != nil {
+		t.Fatalf("BuildTxProof: %v", err)
+	}
+
+	if !VerifyTxProof(block.BlockHeader, target, proof) {
+		t.Fatalf("VerifyTxProof rejected a proof built from the same block")
+	}
+
+	if VerifyTxProof(block.BlockHeader, ts[0].Hash(), proof) {
+		t.Fatalf("VerifyTxProof accepted a proof for the wrong transaction")
+	}
+}
+
+func TestBuildTxProofRejectsUnknownTx(t *testing.T) {
+	ts := TransactionSlice{Transaction{Signature: []byte("only")}}
+	block := &Block{BlockHeader: &BlockHeader{}, TransactionSlice: &ts}
+
+	if _, err := BuildTxProof(block, []byte("not-in-block")); err == nil {
+		t.Fatalf("expected error for a transaction hash not present in the block")
+	}
+}
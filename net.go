@@ -3,7 +3,6 @@ package blockchain
 import (
 	_ "fmt"
 	"io"
-	"log"
 	"net"
 	"time"
 )
@@ -14,7 +13,7 @@ const (
 
 	NETWORK_KEY_SIZE = 80
 
-	TRANSACTION_HEADER_SIZE = NETWORK_KEY_SIZE /* from key */ + NETWORK_KEY_SIZE /* to key */ + 4 /* int32 timestamp */ + 32 /* sha256 payload hash */ + 4 /* int32 payload length */ + 4 /* int32 nonce */
+	TRANSACTION_HEADER_SIZE = NETWORK_KEY_SIZE /* from key */ + NETWORK_KEY_SIZE /* to key */ + 4 /* int32 timestamp */ + 32 /* sha256 payload hash */ + 4 /* int32 payload length */ + 4 /* int32 nonce */ + 4 /* int32 sender sequence */
 
 	BLOCK_HEADER_SIZE = NETWORK_KEY_SIZE /* origin key */ + 4 /* int32 timestamp */ + 32 /* prev block hash */ + 32 /* merkel tree hash */ + 4 /* int32 nonce */
 
@@ -30,6 +29,8 @@ type Network struct {
 	ConnectionCallback NodeChannel
 	BroadcastQueue     chan Message
 	IncomingMessages   chan Message
+
+	log Logger
 }
 
 func NewNetwork(addr string) *Network {
@@ -40,10 +41,16 @@ func NewNetwork(addr string) *Network {
 		ConnectionCallback: make(NodeChannel),
 		Address:            addr,
 		Nodes:              Nodes{},
+		log:                NewStdLogger(),
 	}
 	return n
 }
 
+// SetLogger overrides the network's default logger.
+func (n *Network) SetLogger(l Logger) {
+	n.log = l
+}
+
 func (n *Network) Run() error {
 	go n.watchConnQueue()
 
@@ -73,7 +80,7 @@ func (n *Network) watchConnQueue() {
 		if address != n.Address && n.Nodes[address] == nil {
 			go func() {
 				if err := dialNode(address, 5*time.Second, false, n.ConnectionCallback, n.IncomingMessages); err != nil {
-					log.Println("ERR dialNode", err)
+					n.log.Error("dial node failed", "addr", address, "err", err)
 				}
 			}()
 		}
@@ -92,18 +99,18 @@ func (n *Network) startListening() (NodeChannel, error) {
 			conn, err := l.AcceptTCP()
 			if err != nil {
 				if err != io.EOF {
-					log.Println("ERR", err)
+					n.log.Error("accept failed", "err", err)
 				}
 				continue
 			}
 
-			log.Println("Connecting", conn.RemoteAddr().String())
+			n.log.Info("connecting", "peer", conn.RemoteAddr().String())
 			nd := NewNode(conn, inMsg)
 			cb <- nd
 		}
 	}(listener, n.IncomingMessages)
 
-	log.Println("Listening on:", n.Address)
+	n.log.Info("listening", "addr", n.Address)
 	return cb, nil
 }
 
@@ -111,10 +118,10 @@ func (n *Network) BroadcastMessage(message Message) {
 	b, _ := message.MarshalBinary()
 
 	for k, node := range n.Nodes {
-		log.Println("Broadcasting:", k)
+		n.log.Debug("broadcasting", "peer", k)
 		go func() {
 			if _, err := node.TCPConn.Write(b); err != nil {
-				log.Println("Error Broadcasting to", node.TCPConn.RemoteAddr())
+				n.log.Error("broadcast to peer failed", "peer", node.TCPConn.RemoteAddr(), "err", err)
 			}
 		}()
 	}
@@ -176,6 +183,6 @@ func GetIpAddress() []string {
 */
 func networkError(err error) {
 	if err != nil && err != io.EOF {
-		log.Println("[ERR] Blockchain network:", err)
+		defaultLogger.Error("blockchain network error", "err", err)
 	}
 }
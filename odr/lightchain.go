@@ -0,0 +1,82 @@
+package odr
+
+import (
+	"bytes"
+	"fmt"
+
+	blockchain "github.com/ipkg/blockchain"
+)
+
+// LightChain is the light-client counterpart to a full Blockchain: instead
+// of storing whole blocks, it holds only their headers in a HeaderChain and
+// leans on an OdrBackend to pull whatever body or merkle proof it actually
+// needs from a full peer, verifying each against a header it already
+// trusts rather than a full copy of the chain.
+type LightChain struct {
+	backend OdrBackend
+	headers blockchain.HeaderChain
+}
+
+// NewLightChain returns an empty LightChain served by backend.
+func NewLightChain(backend OdrBackend) *LightChain {
+	return &LightChain{backend: backend}
+}
+
+// Len returns the number of headers held.
+func (lc *LightChain) Len() int { return lc.headers.Len() }
+
+// Head returns the most recently accepted header, or nil if the chain is
+// empty.
+func (lc *LightChain) Head() *blockchain.BlockHeader { return lc.headers.Last() }
+
+// AddHeader appends h to the chain, rejecting it unless it extends the
+// current head - the one piece of chain-of-custody a light client can
+// check for itself without the full body.
+func (lc *LightChain) AddHeader(h *blockchain.BlockHeader) error {
+	if head := lc.headers.Last(); head != nil && !bytes.Equal(h.PrevBlock, head.Hash()) {
+		return fmt.Errorf("odr: header %x does not extend current head %x", h.Hash(), head.Hash())
+	}
+	lc.headers.Add(h)
+	return nil
+}
+
+// FetchHeader requests req.Hash's header from a full peer and, on success,
+// appends it to the chain.
+func (lc *LightChain) FetchHeader(req OdrBlockHeader) (*blockchain.BlockHeader, error) {
+	h, err := lc.backend.GetHeader(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := lc.AddHeader(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// VerifyTx fetches a merkle proof from a full peer and checks it against
+// the locally held header for blockHash, confirming txHash is included
+// under that header's MerkelRoot without ever downloading the full body -
+// the SPV check this light chain exists to make.
+func (lc *LightChain) VerifyTx(blockHash, txHash []byte) (bool, error) {
+	header := lc.headerByHash(blockHash)
+	if header == nil {
+		return false, fmt.Errorf("odr: header for block %x not held by this light chain", blockHash)
+	}
+
+	proof, err := lc.backend.GetTxProof(OdrTxProof{BlockHash: blockHash, TxHash: txHash})
+	if err != nil {
+		return false, err
+	}
+
+	return blockchain.VerifyTxProof(header, txHash, proof), nil
+}
+
+// headerByHash returns the held header with the given hash, or nil.
+func (lc *LightChain) headerByHash(hash []byte) *blockchain.BlockHeader {
+	for _, h := range lc.headers {
+		if bytes.Equal(h.Hash(), hash) {
+			return h
+		}
+	}
+	return nil
+}
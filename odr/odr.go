@@ -0,0 +1,97 @@
+// Package odr implements on-demand retrieval for light clients: nodes that
+// keep only a HeaderChain and ask full peers, over the same chord overlay,
+// for whatever block bodies or transaction proofs they actually need.
+package odr
+
+import (
+	"errors"
+	"time"
+
+	blockchain "github.com/ipkg/blockchain"
+)
+
+// ErrNoPeers is returned when a request has no full peer available to serve it.
+var ErrNoPeers = errors.New("odr: no peers available")
+
+// OdrBlockHeader requests the header of the block with the given hash.
+type OdrBlockHeader struct {
+	ReqID    uint64
+	Deadline time.Time
+	Hash     []byte
+}
+
+// OdrBlockBody requests the full body of the block with the given hash.
+type OdrBlockBody struct {
+	ReqID    uint64
+	Deadline time.Time
+	Hash     []byte
+}
+
+// OdrTxProof requests a merkle proof that TxHash is included under the
+// MerkelRoot of the block with the given hash.
+type OdrTxProof struct {
+	ReqID     uint64
+	Deadline  time.Time
+	BlockHash []byte
+	TxHash    []byte
+}
+
+// OdrBackend is implemented by anything that can serve on-demand retrieval
+// requests on behalf of a light client.
+type OdrBackend interface {
+	GetHeader(req OdrBlockHeader) (*blockchain.BlockHeader, error)
+	GetBody(req OdrBlockBody) (*blockchain.Block, error)
+	GetTxProof(req OdrTxProof) (*blockchain.MerkleProof, error)
+}
+
+// ChordOdrBackend implements OdrBackend over a blockchain.ChordTransport,
+// the same chord overlay full nodes use to gossip blocks and txs, picking
+// the best-known peer to serve each request.
+type ChordOdrBackend struct {
+	transport *blockchain.ChordTransport
+}
+
+// NewChordOdrBackend returns an OdrBackend backed by transport.
+func NewChordOdrBackend(transport *blockchain.ChordTransport) *ChordOdrBackend {
+	return &ChordOdrBackend{transport: transport}
+}
+
+func (b *ChordOdrBackend) peerHost() (string, error) {
+	// Only a full node stores bodies and can build tx proofs, so on-demand
+	// retrieval must never be routed to a light peer.
+	info, ok := b.transport.BestPeerWithService(blockchain.ServiceFullNode)
+	if !ok {
+		return "", ErrNoPeers
+	}
+	return info.Host, nil
+}
+
+// GetHeader satisfies OdrBackend by requesting req.Hash's header from a
+// connected peer.
+func (b *ChordOdrBackend) GetHeader(req OdrBlockHeader) (*blockchain.BlockHeader, error) {
+	host, err := b.peerHost()
+	if err != nil {
+		return nil, err
+	}
+	return b.transport.GetHeader(host, req.Hash)
+}
+
+// GetBody satisfies OdrBackend by requesting req.Hash's full body from a
+// connected peer.
+func (b *ChordOdrBackend) GetBody(req OdrBlockBody) (*blockchain.Block, error) {
+	host, err := b.peerHost()
+	if err != nil {
+		return nil, err
+	}
+	return b.transport.GetBody(host, req.Hash)
+}
+
+// GetTxProof satisfies OdrBackend by requesting a merkle proof of
+// req.TxHash's inclusion in req.BlockHash from a connected peer.
+func (b *ChordOdrBackend) GetTxProof(req OdrTxProof) (*blockchain.MerkleProof, error) {
+	host, err := b.peerHost()
+	if err != nil {
+		return nil, err
+	}
+	return b.transport.GetTxProof(host, req.BlockHash, req.TxHash)
+}
@@ -0,0 +1,343 @@
+package blockchain
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// knownHashLimit bounds how many block/tx hashes a Peer remembers having
+	// already announced to or received from us.
+	knownHashLimit = 1024
+
+	// peerSendQueueSize bounds how many outstanding broadcast messages a
+	// Peer will buffer before new ones are dropped rather than blocking the
+	// sender.
+	peerSendQueueSize = 64
+)
+
+// lruHashSet is a small bounded set of byte-slice hashes, evicting the
+// oldest entry once the limit is reached.  It is used to remember which
+// blocks or transactions a peer already knows about so we don't
+// re-broadcast announcements back to it.
+type lruHashSet struct {
+	mu    sync.Mutex
+	limit int
+	order []string
+	set   map[string]struct{}
+}
+
+func newLRUHashSet(limit int) *lruHashSet {
+	return &lruHashSet{limit: limit, set: map[string]struct{}{}}
+}
+
+// Has reports whether hash is already in the set.
+func (l *lruHashSet) Has(hash []byte) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, ok := l.set[string(hash)]
+	return ok
+}
+
+// Add records hash as known, evicting the oldest entry if the set is full.
+func (l *lruHashSet) Add(hash []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	k := string(hash)
+	if _, ok := l.set[k]; ok {
+		return
+	}
+
+	if len(l.order) >= l.limit {
+		oldest := l.order[0]
+		l.order = l.order[1:]
+		delete(l.set, oldest)
+	}
+	l.order = append(l.order, k)
+	l.set[k] = struct{}{}
+}
+
+// Peer tracks a single connected remote node: its negotiated handshake
+// info, what it has already announced to or received from us, and
+// bookkeeping used to rate and, if necessary, drop it.
+type Peer struct {
+	id   string // advertised host from the handshake, used as the peer's identity
+	conn net.Conn
+	info PeerInfo
+
+	mu     sync.RWMutex
+	height uint64
+
+	knownBlocks *lruHashSet
+	knownTxs    *lruHashSet
+
+	reputation int32
+	dropped    int32
+
+	sendq chan []byte
+	done  chan struct{}
+
+	// wmu guards every write to conn.  writeLoop drains sendq onto conn
+	// asynchronously while a synchronous request/response round trip on the
+	// same connection writes directly; without this lock the two writers can
+	// interleave bytes mid-message and corrupt the bencode framing for both.
+	wmu sync.Mutex
+}
+
+func newPeer(id string, conn net.Conn, info PeerInfo) *Peer {
+	p := &Peer{
+		id:          id,
+		conn:        conn,
+		info:        info,
+		height:      info.Height,
+		knownBlocks: newLRUHashSet(knownHashLimit),
+		knownTxs:    newLRUHashSet(knownHashLimit),
+		sendq:       make(chan []byte, peerSendQueueSize),
+		done:        make(chan struct{}),
+	}
+	go p.writeLoop()
+	return p
+}
+
+// Info returns the peer's negotiated handshake data.
+func (p *Peer) Info() PeerInfo { return p.info }
+
+// Height returns the peer's last known advertised block height.
+func (p *Peer) Height() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.height
+}
+
+// UpdateHeight records a new advertised height for the peer, e.g. after a
+// block announcement, keeping the highest value seen.
+func (p *Peer) UpdateHeight(h uint64) {
+	p.mu.Lock()
+	if h > p.height {
+		p.height = h
+	}
+	p.mu.Unlock()
+}
+
+// HasBlock reports whether the peer is already known to have the block with
+// the given hash.
+func (p *Peer) HasBlock(hash []byte) bool { return p.knownBlocks.Has(hash) }
+
+// MarkBlock records that the peer knows about the block with the given
+// hash, so we don't re-announce it.
+func (p *Peer) MarkBlock(hash []byte) { p.knownBlocks.Add(hash) }
+
+// HasTx reports whether the peer is already known to have the transaction
+// with the given hash.
+func (p *Peer) HasTx(hash []byte) bool { return p.knownTxs.Has(hash) }
+
+// MarkTx records that the peer knows about the transaction with the given
+// hash, so we don't re-announce it.
+func (p *Peer) MarkTx(hash []byte) { p.knownTxs.Add(hash) }
+
+// Dropped returns the number of messages dropped, either because the peer's
+// send queue was full or because writing them to the connection failed.
+func (p *Peer) Dropped() int32 { return atomic.LoadInt32(&p.dropped) }
+
+// Send queues a pre-encoded message for delivery to the peer without
+// blocking the caller.  A slow peer whose queue is already full has the
+// message dropped, and its dropped-count incremented, rather than stalling
+// whoever is broadcasting.
+func (p *Peer) Send(b []byte) {
+	select {
+	case p.sendq <- b:
+	default:
+		atomic.AddInt32(&p.dropped, 1)
+	}
+}
+
+func (p *Peer) writeLoop() {
+	for {
+		select {
+		case b := <-p.sendq:
+			if _, err := p.Write(b); err != nil {
+				atomic.AddInt32(&p.dropped, 1)
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// Write sends b on the peer's connection, serializing it against every
+// other writer of the same connection (writeLoop's broadcast traffic and any
+// synchronous request/response round trip) so their bytes can never
+// interleave on the wire.
+func (p *Peer) Write(b []byte) (int, error) {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	return p.conn.Write(b)
+}
+
+// sendMsg bencode-encodes v into a single buffer and writes it to the peer
+// with one Write call, so it can't be interleaved mid-message with
+// writeLoop's broadcast traffic on the same connection.
+func (p *Peer) sendMsg(v interface{}) error {
+	msg, err := encodeMsg(v)
+	if err != nil {
+		return err
+	}
+	_, err = p.Write(msg)
+	return err
+}
+
+// stop shuts down the peer's writeLoop and closes its connection, so neither
+// a pending broadcast write nor the connection itself outlives the peer's
+// removal from the PeerSet.  conn is nil for peers constructed directly in
+// tests, hence the guard.
+func (p *Peer) stop() {
+	close(p.done)
+	if p.conn != nil {
+		p.conn.Close()
+	}
+}
+
+// PeerSet tracks every currently connected Peer, keyed by advertised host,
+// and provides the selectors broadcast uses to decide fanout.
+type PeerSet struct {
+	mu    sync.RWMutex
+	peers map[string]*Peer
+}
+
+// NewPeerSet creates an empty PeerSet.
+func NewPeerSet() *PeerSet {
+	return &PeerSet{peers: map[string]*Peer{}}
+}
+
+// Register adds p to the set, replacing and stopping any existing peer
+// registered under the same id.
+func (ps *PeerSet) Register(p *Peer) {
+	ps.mu.Lock()
+	if old, ok := ps.peers[p.id]; ok {
+		old.stop()
+	}
+	ps.peers[p.id] = p
+	ps.mu.Unlock()
+}
+
+// Remove stops and forgets the peer registered under id, if any,
+// regardless of which connection registered it.  Used for a deliberate
+// drop-by-id (e.g. the public StopPeer API); connection teardown should use
+// RemovePeer instead so a stale connection can't tear down a newer
+// registration made after a reconnect.
+func (ps *PeerSet) Remove(id string) {
+	ps.mu.Lock()
+	if p, ok := ps.peers[id]; ok {
+		p.stop()
+		delete(ps.peers, id)
+	}
+	ps.mu.Unlock()
+}
+
+// RemovePeer stops and forgets p, but only if p is still the peer currently
+// registered under its id.  A connection whose peer was already replaced by
+// a later reconnect is a no-op here, instead of tearing down the live
+// registration.
+func (ps *PeerSet) RemovePeer(p *Peer) {
+	ps.mu.Lock()
+	if cur, ok := ps.peers[p.id]; ok && cur == p {
+		cur.stop()
+		delete(ps.peers, p.id)
+	}
+	ps.mu.Unlock()
+}
+
+// Peer returns the peer registered under id, if connected.
+func (ps *PeerSet) Peer(id string) (*Peer, bool) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	p, ok := ps.peers[id]
+	return p, ok
+}
+
+// Len returns the number of connected peers.
+func (ps *PeerSet) Len() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.peers)
+}
+
+// All returns every connected peer.
+func (ps *PeerSet) All() []*Peer {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	out := make([]*Peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		out = append(out, p)
+	}
+	return out
+}
+
+// BestPeer returns the connected peer advertising the highest block height,
+// or nil if there are no connected peers.
+func (ps *PeerSet) BestPeer() *Peer {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	var best *Peer
+	for _, p := range ps.peers {
+		if best == nil || p.Height() > best.Height() {
+			best = p
+		}
+	}
+	return best
+}
+
+// BestPeerWithService returns the connected peer advertising the highest
+// block height among those whose handshake Services bitfield includes every
+// bit set in svc, or nil if none qualify.  Used to keep requests that only a
+// certain kind of peer can serve - e.g. on-demand retrieval, which needs a
+// full node - from being routed to a peer that can't answer them.
+func (ps *PeerSet) BestPeerWithService(svc uint64) *Peer {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	var best *Peer
+	for _, p := range ps.peers {
+		if p.info.Services&svc != svc {
+			continue
+		}
+		if best == nil || p.Height() > best.Height() {
+			best = p
+		}
+	}
+	return best
+}
+
+// PeersWithoutBlock returns every connected peer not already known to have
+// the block with the given hash.
+func (ps *PeerSet) PeersWithoutBlock(hash []byte) []*Peer {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	out := make([]*Peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		if !p.HasBlock(hash) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// PeersWithoutTx returns every connected peer not already known to have the
+// transaction with the given hash.
+func (ps *PeerSet) PeersWithoutTx(hash []byte) []*Peer {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	out := make([]*Peer, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		if !p.HasTx(hash) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,21 @@
+package blockchain
+
+import (
+	"net"
+	"testing"
+)
+
+// TestPeerStopClosesConn checks that stop() tears down the live connection,
+// not just the writeLoop, so StopPeer/reconnect teardown/a fetcher timeout
+// actually disconnects the peer instead of leaking the socket.
+func TestPeerStopClosesConn(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+
+	p := newPeer("peer1:9119", local, PeerInfo{})
+	p.stop()
+
+	if _, err := local.Write([]byte("x")); err == nil {
+		t.Fatalf("expected write on a stopped peer's connection to fail")
+	}
+}
@@ -1,11 +1,14 @@
 package blockchain
 
 import (
+	"bytes"
 	"fmt"
 	"io"
-	"log"
+	"math"
+	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	chord "github.com/euforia/go-chord"
@@ -19,10 +22,112 @@ const (
 	reqTypeBlock
 	reqTypeBlockBroadcast
 	reqTypeTxBroadcast
+	// reqTypeBlockAnnounce carries only a block's hash, height and parent
+	// hash, letting a peer decide for itself whether to fetch the body.
+	reqTypeBlockAnnounce
+	// reqTypeGetBlockBodies requests full blocks by hash, in response to a
+	// previously received reqTypeBlockAnnounce.
+	reqTypeGetBlockBodies
+	// reqTypeGetHeader requests just the header of a block by hash, for
+	// light clients that don't store full bodies.
+	reqTypeGetHeader
+	// reqTypeGetTxProof requests a merkle proof that a transaction is
+	// included in a given block.
+	reqTypeGetTxProof
 )
 
+const (
+	// ProtoVersion is the protocol version spoken by this node.
+	ProtoVersion uint32 = 1
+	// MinProtoVersion is the lowest peer protocol version that will be
+	// accepted on a connection.  Peers advertising anything lower are
+	// disconnected during the handshake.
+	MinProtoVersion uint32 = 1
+
+	// UserAgent identifies this implementation to peers during the handshake.
+	UserAgent = "/ipkg:blockchain:0.1/"
+)
+
+// Services bitfield flags advertised in a handshake.
+const (
+	// ServiceFullNode indicates the peer stores and serves the full chain.
+	ServiceFullNode uint64 = 1 << iota
+)
+
+// bcHeader precedes every request and response on the wire.  ReqID is a
+// transport-wide, per-request counter stamped by the requesting side and
+// echoed back unchanged by the responder, so a single failed round trip can
+// be traced across both sides of a connection's logs.
 type bcHeader struct {
-	T byte
+	T     byte
+	ReqID uint64
+}
+
+// bcHandshake is exchanged first, in both directions, on every newly
+// established connection, before any other request is served.  It lets each
+// side learn who it is talking to - and reject obviously bad peers - before
+// trusting anything further on the wire.
+type bcHandshake struct {
+	Version   uint32
+	Services  uint64
+	Timestamp uint32
+	Host      string
+	Height    uint64
+	Nonce     uint64
+	UserAgent string
+}
+
+// PeerInfo holds the handshake data negotiated with a connected peer.
+type PeerInfo bcHandshake
+
+// blockAnnounce is the lightweight message sent in place of a full block to
+// peers not chosen for a full push; the receiver decides for itself whether
+// it needs the body and, if so, fetches it with reqTypeGetBlockBodies.  Height
+// is the announcer's local chain height as of this block, letting the
+// receiver update its record of the peer without waiting on a fresh
+// handshake.  PrevHash is the block's parent hash.
+type blockAnnounce struct {
+	Hash     []byte
+	PrevHash []byte
+	Height   uint64
+}
+
+// getBlockBodiesReq requests full blocks by hash.
+type getBlockBodiesReq struct {
+	Hashes [][]byte
+}
+
+// blockBodies is the response to a getBlockBodiesReq.
+type blockBodies struct {
+	Blocks []Block
+}
+
+// txProofReq requests a merkle proof that TxHash is included in the block
+// with hash BlockHash.
+type txProofReq struct {
+	BlockHash []byte
+	TxHash    []byte
+}
+
+// headerStore is optionally implemented by a BlockStore to serve header-only
+// lookups for light clients without reading the full block body.
+type headerStore interface {
+	GetHeader(hash []byte) *BlockHeader
+}
+
+// txProofStore is optionally implemented by a BlockStore to serve merkle
+// proofs of transaction inclusion for light clients.
+type txProofStore interface {
+	TxProof(blockHash, txHash []byte) (*MerkleProof, error)
+}
+
+// ringLookup is the subset of *chord.Ring the transport relies on:
+// resolving this node's own advertised hostname and finding the successors
+// responsible for a given key.  Abstracted out so tests can substitute a
+// fake ring instead of standing up a real chord cluster.
+type ringLookup interface {
+	Hostname() string
+	Lookup(n int, key []byte) ([]*chord.Vnode, error)
 }
 
 // ChordTransport for the blockchain
@@ -33,7 +138,7 @@ type ChordTransport struct {
 	dialTimeout time.Duration
 
 	cc   *chord.Config
-	ring *chord.Ring
+	ring ringLookup
 
 	olock sync.Mutex
 	// outbound connections
@@ -43,12 +148,33 @@ type ChordTransport struct {
 	// inbound connections
 	inbound map[net.Conn]bool
 
+	// nonce identifies this node's session and is used to detect self-connects
+	nonce uint64
+
+	// reqSeq assigns each outgoing request a unique ReqID for log correlation.
+	reqSeq uint64
+
+	// log receives structured, leveled events for this transport.  Defaults
+	// to NewStdLogger(); override with SetLogger.
+	log Logger
+
+	// peerSet holds every connected peer, keyed by its advertised host
+	peerSet *PeerSet
+
+	plock sync.Mutex
+	// maps a live connection back to the Peer it negotiated, so it can be
+	// removed from peerSet when the connection goes away
+	connPeers map[net.Conn]*Peer
+
 	// channel to send blocks from network
 	bch chan<- Block
 	// channel to send tx from network
 	tch chan<- *Tx
 
 	store BlockStore
+
+	// fetch pulls full bodies in response to announcements received from peers
+	fetch *fetcher
 }
 
 // NewChordTransport initializes a new chord based transport for the blockchain.  The chord config
@@ -61,32 +187,297 @@ func NewChordTransport(sock *mux.Layer, cfg *chord.Config, ring *chord.Ring) *Ch
 		ring:        ring,
 		outbound:    map[string][]net.Conn{},
 		inbound:     map[net.Conn]bool{},
+		nonce:       rand.New(rand.NewSource(time.Now().UnixNano())).Uint64(),
+		peerSet:     NewPeerSet(),
+		connPeers:   map[net.Conn]*Peer{},
+		log:         NewStdLogger(),
 	}
 
 	return ct
 }
 
+// SetLogger overrides the transport's default logger.
+func (ct *ChordTransport) SetLogger(l Logger) {
+	ct.log = l
+}
+
+// nextReqID returns the next transport-wide request id, for log correlation.
+func (ct *ChordTransport) nextReqID() uint64 {
+	return atomic.AddUint64(&ct.reqSeq, 1)
+}
+
+// header builds a bcHeader of the given type, stamped with a fresh ReqID.
+func (ct *ChordTransport) header(typ byte) *bcHeader {
+	return &bcHeader{T: typ, ReqID: ct.nextReqID()}
+}
+
+// PeerCount returns the number of currently connected peers.
+func (ct *ChordTransport) PeerCount() int {
+	return ct.peerSet.Len()
+}
+
+// GetPeerInfos returns the negotiated handshake info for every connected
+// peer.
+func (ct *ChordTransport) GetPeerInfos() []PeerInfo {
+	peers := ct.peerSet.All()
+
+	out := make([]PeerInfo, len(peers))
+	for i, p := range peers {
+		out[i] = p.Info()
+	}
+	return out
+}
+
+// StopPeer disconnects the peer advertising the given host, if connected.
+func (ct *ChordTransport) StopPeer(id string) {
+	ct.peerSet.Remove(id)
+}
+
+// BestPeer returns the handshake info of the connected peer advertising the
+// highest block height, and whether one was found.
+func (ct *ChordTransport) BestPeer() (PeerInfo, bool) {
+	p := ct.peerSet.BestPeer()
+	if p == nil {
+		return PeerInfo{}, false
+	}
+	return p.Info(), true
+}
+
+// BestPeerWithService returns the handshake info of the connected peer
+// advertising the highest block height among those advertising every
+// service bit in svc - e.g. ServiceFullNode, for on-demand-retrieval
+// requests that only a full node can serve - and whether one was found.
+func (ct *ChordTransport) BestPeerWithService(svc uint64) (PeerInfo, bool) {
+	p := ct.peerSet.BestPeerWithService(svc)
+	if p == nil {
+		return PeerInfo{}, false
+	}
+	return p.Info(), true
+}
+
+// heightProvider is optionally implemented by a BlockStore to report the
+// height of the chain it is backing.  It is consulted, best-effort, to fill
+// in the Height field of our outgoing handshake.
+type heightProvider interface {
+	Height() uint64
+}
+
+func (ct *ChordTransport) localHeight() uint64 {
+	if hp, ok := ct.store.(heightProvider); ok {
+		return hp.Height()
+	}
+	return 0
+}
+
+// handshake performs the version exchange that precedes any other traffic on
+// a newly established connection.  It is sent first, in both directions;
+// peers speaking a protocol version below MinProtoVersion, or echoing back
+// our own session nonce (a self-connect, as Bitcoin detects it), are
+// rejected by returning an error - the caller is expected to close the
+// connection.  On success the negotiated Peer is registered in ct.peerSet.
+func (ct *ChordTransport) handshake(conn net.Conn) (*Peer, error) {
+	local := &bcHandshake{
+		Version:   ProtoVersion,
+		Services:  ServiceFullNode,
+		Timestamp: uint32(time.Now().Unix()),
+		Host:      ct.ring.Hostname(),
+		Height:    ct.localHeight(),
+		Nonce:     ct.nonce,
+		UserAgent: UserAgent,
+	}
+
+	enc := bencode.NewEncoder(conn)
+	if err := enc.Encode(local); err != nil {
+		return nil, err
+	}
+
+	var remote bcHandshake
+	dec := bencode.NewDecoder(conn)
+	if err := dec.Decode(&remote); err != nil {
+		return nil, err
+	}
+
+	if remote.Version < MinProtoVersion {
+		return nil, fmt.Errorf("peer protocol version %d below minimum %d", remote.Version, MinProtoVersion)
+	}
+	if remote.Nonce == ct.nonce {
+		return nil, fmt.Errorf("self connect detected")
+	}
+
+	p := newPeer(remote.Host, conn, PeerInfo(remote))
+	ct.peerSet.Register(p)
+
+	ct.plock.Lock()
+	ct.connPeers[conn] = p
+	ct.plock.Unlock()
+
+	return p, nil
+}
+
+func (ct *ChordTransport) removePeer(conn net.Conn) {
+	ct.plock.Lock()
+	p, ok := ct.connPeers[conn]
+	delete(ct.connPeers, conn)
+	ct.plock.Unlock()
+
+	if ok {
+		// RemovePeer, not Remove(p.id): conn may be a stale connection
+		// whose peer was already replaced in peerSet by a later reconnect,
+		// and we must not tear down that newer registration.
+		ct.peerSet.RemovePeer(p)
+	}
+}
+
+// peerForConn returns the Peer negotiated on conn, if any.
+func (ct *ChordTransport) peerForConn(conn net.Conn) (*Peer, bool) {
+	ct.plock.Lock()
+	defer ct.plock.Unlock()
+	p, ok := ct.connPeers[conn]
+	return p, ok
+}
+
+// connWriter returns the write side of conn, routed through its registered
+// Peer so a synchronous request/response round trip can't interleave its
+// bytes with that peer's broadcast writeLoop on the same connection.  Falls
+// back to conn itself on the rare path where no Peer is registered yet.
+func (ct *ChordTransport) connWriter(conn net.Conn) io.Writer {
+	if p, ok := ct.peerForConn(conn); ok {
+		return p
+	}
+	return conn
+}
+
+// encodeMsg bencode-encodes every value in order into a single buffer.
+// bencode.Encoder issues many separate Write calls per struct (one per
+// key, plus framing), so encoding straight onto a shared connection lets a
+// concurrent writer - writeLoop's broadcast traffic routed through the same
+// Peer - interleave its own message in the middle of this one.  Encoding
+// into a buffer first and writing it with a single Write call makes each
+// logical message atomic on the wire.
+func encodeMsg(values ...interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := bencode.NewEncoder(&buf)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
 // Initialize is called by the blockchain with the tx and block queues.  These are
 // used when blocks and txs are received over the network to submit to the engine
 // for processing.
 func (ct *ChordTransport) Initialize(tx chan<- *Tx, blk chan<- Block, store BlockStore) error {
 	ct.bch = blk
 	ct.tch = tx
-	ct.store = store
+	ct.store = withHeaderSupport(store)
+	ct.fetch = newFetcher(ct)
 
 	go ct.listen()
 
 	return nil
 }
 
-// BroadcastBlock to the network
+// withHeaderSupport wraps store in a HeaderBlockStore unless it already
+// implements headerStore and txProofStore itself, so reqTypeGetHeader and
+// reqTypeGetTxProof have real data to answer with instead of falling through
+// to handleConn's zero-value case.
+func withHeaderSupport(store BlockStore) BlockStore {
+	_, hasHeader := store.(headerStore)
+	_, hasProof := store.(txProofStore)
+	if hasHeader && hasProof {
+		return store
+	}
+	return NewHeaderBlockStore(store)
+}
+
+// BroadcastBlock to the network.  Peers already known to have the block are
+// skipped.  Of the rest, only a random sqrt(N) subset (as Ethereum does) is
+// pushed the full block; everyone else just gets a lightweight announcement
+// and fetches the body itself if it turns out to need it.  Hosts we aren't
+// already connected to as peers fall back to a full-block push via the
+// hash's chord successors.
 func (ct *ChordTransport) BroadcastBlock(blk *Block) error {
-	return ct.broadcast(reqTypeBlockBroadcast, blk.Hash(), blk)
+	hsh := blk.Hash()
+	peers := ct.peerSet.PeersWithoutBlock(hsh)
+	full, announce := splitBroadcastPeers(peers)
+
+	ann := &blockAnnounce{Hash: hsh, PrevHash: blk.PrevBlock, Height: ct.localHeight()}
+
+	go func() {
+		reached := make(map[string]bool, len(peers))
+
+		for _, p := range full {
+			reached[p.id] = true
+			if err := ct.sendToPeer(p, reqTypeBlockBroadcast, blk); err != nil {
+				ct.log.Error("send block to peer failed", "peer", p.id, "hash", fmt.Sprintf("%x", hsh), "err", err)
+				continue
+			}
+			p.MarkBlock(hsh)
+		}
+
+		for _, p := range announce {
+			reached[p.id] = true
+			if err := ct.sendToPeer(p, reqTypeBlockAnnounce, ann); err != nil {
+				ct.log.Error("announce block to peer failed", "peer", p.id, "hash", fmt.Sprintf("%x", hsh), "err", err)
+				continue
+			}
+			p.MarkBlock(hsh)
+		}
+
+		nodes, err := ct.ring.Lookup(ct.cc.NumSuccessors, hsh)
+		if err != nil {
+			ct.log.Error("chord lookup failed", "hash", fmt.Sprintf("%x", hsh), "err", err)
+			return
+		}
+
+		for _, host := range VnodeSlice(nodes).UniqueHosts() {
+			// skip self, hosts we already reached as peers, and connected
+			// peers already known to have the block
+			_, connected := ct.peerSet.Peer(host)
+			if host == ct.ring.Hostname() || reached[host] || connected {
+				continue
+			}
+
+			hdr := ct.header(reqTypeBlockBroadcast)
+			if err := ct.doRequest(host, hdr, blk, nil); err != nil {
+				ct.log.Error("broadcast block failed", "peer", host, "reqID", hdr.ReqID, "hash", fmt.Sprintf("%x", hsh), "err", err)
+			}
+		}
+	}()
+
+	return nil
 }
 
-// BroadcastTransaction to the network
+// splitBroadcastPeers splits peers into a random subset sized sqrt(N) that
+// receives the full block, and the remainder, which only receives a
+// lightweight announcement.
+func splitBroadcastPeers(peers []*Peer) (full, announce []*Peer) {
+	n := len(peers)
+	if n == 0 {
+		return nil, nil
+	}
+
+	fullCount := int(math.Sqrt(float64(n)))
+	if fullCount < 1 {
+		fullCount = 1
+	}
+
+	shuffled := make([]*Peer, n)
+	copy(shuffled, peers)
+	rand.Shuffle(n, func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	return shuffled[:fullCount], shuffled[fullCount:]
+}
+
+// BroadcastTransaction to the network.  Peers already known to have the
+// transaction are skipped; everyone else is reached through the peer set,
+// falling back to the hash's chord successors for any hosts we aren't
+// already connected to.
 func (ct *ChordTransport) BroadcastTransaction(tx *Tx) error {
-	return ct.broadcast(reqTypeTxBroadcast, tx.Hash(), tx)
+	hsh := tx.Hash()
+	return ct.broadcast(reqTypeTxBroadcast, hsh, tx, ct.peerSet.PeersWithoutTx(hsh), (*Peer).MarkTx)
 }
 
 // LastBlock of the chain per the given host
@@ -99,19 +490,63 @@ func (ct *ChordTransport) FirstBlock(host string) (*Block, error) {
 	return ct.getBlockByType(reqTypeFirstBlock, host)
 }
 
+// GetHeader requests just the header of the block with the given hash from
+// host, for light clients that don't store full bodies.
+func (ct *ChordTransport) GetHeader(host string, hash []byte) (*BlockHeader, error) {
+	var hdr BlockHeader
+	if err := ct.doRequest(host, ct.header(reqTypeGetHeader), hash, &hdr); err != nil {
+		return nil, err
+	}
+	return &hdr, nil
+}
+
+// GetBody requests the full body of the block with the given hash from
+// host, e.g. for a light client that decided it needs to materialize a
+// block it only has the header for.
+func (ct *ChordTransport) GetBody(host string, hash []byte) (*Block, error) {
+	var blk Block
+	if err := ct.doRequest(host, ct.header(reqTypeBlock), hash, &blk); err != nil {
+		return nil, err
+	}
+	if blk.BlockHeader == nil {
+		return nil, fmt.Errorf("block not found: %x", hash)
+	}
+	return &blk, nil
+}
+
+// GetTxProof requests a merkle proof that the transaction with hash txHash
+// is included in the block with hash blockHash, from host.
+func (ct *ChordTransport) GetTxProof(host string, blockHash, txHash []byte) (*MerkleProof, error) {
+	var proof MerkleProof
+	req := &txProofReq{BlockHash: blockHash, TxHash: txHash}
+	if err := ct.doRequest(host, ct.header(reqTypeGetTxProof), req, &proof); err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
+
 // last or genesis block request
 func (ct *ChordTransport) getBlockByType(typ byte, host string) (*Block, error) {
-	conn, err := ct.getConn(host)
+	conn, isNew, err := ct.getConn(host)
 	if err != nil {
 		return nil, err
 	}
 
+	if isNew {
+		if _, err = ct.handshake(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
 	var blk Block
 
-	enc := bencode.NewEncoder(conn)
-	if err = enc.Encode(&bcHeader{T: typ}); err == nil {
-		dec := bencode.NewDecoder(conn)
-		err = dec.Decode(&blk)
+	var msg []byte
+	if msg, err = encodeMsg(ct.header(typ)); err == nil {
+		if _, err = ct.connWriter(conn).Write(msg); err == nil {
+			dec := bencode.NewDecoder(conn)
+			err = dec.Decode(&blk)
+		}
 	}
 
 	if err != nil {
@@ -120,6 +555,7 @@ func (ct *ChordTransport) getBlockByType(typ byte, host string) (*Block, error)
 		}
 		// don't return conn there is an error.  since we are using udp underneath, it
 		// shouldn't be too expensive to get a new connection.
+		ct.removePeer(conn)
 		conn.Close()
 		return &blk, err
 	}
@@ -128,7 +564,10 @@ func (ct *ChordTransport) getBlockByType(typ byte, host string) (*Block, error)
 	return &blk, nil
 }
 
-func (ct *ChordTransport) getConn(addr string) (net.Conn, error) {
+// getConn returns a connection for addr, reusing a pooled outbound
+// connection when one is available.  The returned bool reports whether the
+// connection was freshly dialed, i.e. still needs a handshake.
+func (ct *ChordTransport) getConn(addr string) (net.Conn, bool, error) {
 	var out net.Conn
 
 	ct.olock.Lock()
@@ -141,10 +580,11 @@ func (ct *ChordTransport) getConn(addr string) (net.Conn, error) {
 	ct.olock.Unlock()
 
 	if out != nil {
-		return out, nil
+		return out, false, nil
 	}
 
-	return ct.sock.Dial(addr, ct.dialTimeout)
+	conn, err := ct.sock.Dial(addr, ct.dialTimeout)
+	return conn, err == nil, err
 }
 
 func (ct *ChordTransport) returnConn(conn net.Conn) {
@@ -161,38 +601,73 @@ func (ct *ChordTransport) returnConn(conn net.Conn) {
 	ct.outbound[addr] = append(p, conn)
 }
 
-func (ct *ChordTransport) broadcast(typ byte, hsh []byte, v interface{}) error {
-	nodes, err := ct.ring.Lookup(ct.cc.NumSuccessors, hsh)
-	if err != nil {
-		return err
-	}
+// broadcast fans v out to peers, via their send queues so a slow peer can't
+// stall the rest of the broadcast, marking each as having hsh once sent.
+// Hosts not already covered by peers fall back to hsh's chord successors.
+func (ct *ChordTransport) broadcast(typ byte, hsh []byte, v interface{}, peers []*Peer, mark func(*Peer, []byte)) error {
+	go func() {
+		reached := make(map[string]bool, len(peers))
+		for _, p := range peers {
+			reached[p.id] = true
+			if err := ct.sendToPeer(p, typ, v); err != nil {
+				ct.log.Error("send to peer failed", "peer", p.id, "type", typ, "hash", fmt.Sprintf("%x", hsh), "err", err)
+				continue
+			}
+			mark(p, hsh)
+		}
 
-	go func(vns []*chord.Vnode) {
-		hosts := VnodeSlice(vns).UniqueHosts()
-		for _, host := range hosts {
-			// skip self
-			if host == ct.ring.Hostname() {
+		nodes, err := ct.ring.Lookup(ct.cc.NumSuccessors, hsh)
+		if err != nil {
+			ct.log.Error("chord lookup failed", "hash", fmt.Sprintf("%x", hsh), "err", err)
+			return
+		}
+
+		for _, host := range VnodeSlice(nodes).UniqueHosts() {
+			// skip self, hosts we already reached as peers, and connected
+			// peers skipped because they already have hsh
+			_, connected := ct.peerSet.Peer(host)
+			if host == ct.ring.Hostname() || reached[host] || connected {
 				continue
 			}
 
-			if err := ct.doRequest(host, &bcHeader{T: typ}, v, nil); err != nil {
-				log.Println("ERR", err)
+			hdr := ct.header(typ)
+			if err := ct.doRequest(host, hdr, v, nil); err != nil {
+				ct.log.Error("broadcast failed", "peer", host, "reqID", hdr.ReqID, "type", typ, "hash", fmt.Sprintf("%x", hsh), "err", err)
 			}
 		}
+	}()
+	return nil
+}
 
-	}(nodes)
+// sendToPeer encodes the header and value into a single buffer and queues it
+// on the peer's send queue, so encoding cost is paid once per peer rather
+// than blocking on the write itself.
+func (ct *ChordTransport) sendToPeer(p *Peer, typ byte, v interface{}) error {
+	msg, err := encodeMsg(ct.header(typ), v)
+	if err != nil {
+		return err
+	}
+
+	p.Send(msg)
 	return nil
 }
 
 func (ct *ChordTransport) doRequest(host string, hdr *bcHeader, req, resp interface{}) error {
-	conn, err := ct.getConn(host)
+	conn, isNew, err := ct.getConn(host)
 	if err != nil {
 		return err
 	}
 
-	enc := bencode.NewEncoder(conn)
-	if err = enc.Encode(hdr); err == nil {
-		if err = enc.Encode(req); err == nil {
+	if isNew {
+		if _, err = ct.handshake(conn); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+
+	var msg []byte
+	if msg, err = encodeMsg(hdr, req); err == nil {
+		if _, err = ct.connWriter(conn).Write(msg); err == nil {
 			// optional response param
 			if resp != nil {
 				dec := bencode.NewDecoder(conn)
@@ -207,6 +682,7 @@ func (ct *ChordTransport) doRequest(host string, hdr *bcHeader, req, resp interf
 		}
 		// Don't return conn there is an error.  since we are using udp underneath, it
 		// shouldn't be too expensive to get a new connection.
+		ct.removePeer(conn)
 		conn.Close()
 		return err
 	}
@@ -220,21 +696,17 @@ func (ct *ChordTransport) doRequest(host string, hdr *bcHeader, req, resp interf
 func (ct *ChordTransport) RequestBlocks(hashes ...[]byte) {
 
 	for _, hsh := range hashes {
-		vns, err := ct.ring.Lookup(ct.cc.NumSuccessors, hsh)
-		if err != nil {
-			log.Println("ERR", err)
-			continue
-		}
+		hosts := ct.candidateHosts(hsh)
 
-		uhosts := VnodeSlice(vns).UniqueHosts()
-		for _, host := range uhosts {
+		for _, host := range hosts {
 			if host == ct.ring.Hostname() {
 				continue
 			}
 
 			var blk Block
-			if e := ct.doRequest(host, &bcHeader{T: reqTypeBlock}, hsh, &blk); e != nil {
-				log.Println("ERR", e)
+			hdr := ct.header(reqTypeBlock)
+			if e := ct.doRequest(host, hdr, hsh, &blk); e != nil {
+				ct.log.Error("request block failed", "peer", host, "reqID", hdr.ReqID, "hash", fmt.Sprintf("%x", hsh), "err", e)
 				continue
 			}
 
@@ -247,12 +719,32 @@ func (ct *ChordTransport) RequestBlocks(hashes ...[]byte) {
 	}
 }
 
+// candidateHosts returns the hosts to query for a given hash: already
+// connected peers first, falling back to the hash's chord successors only
+// when we have no peers connected.
+func (ct *ChordTransport) candidateHosts(hsh []byte) []string {
+	if peers := ct.peerSet.All(); len(peers) > 0 {
+		hosts := make([]string, len(peers))
+		for i, p := range peers {
+			hosts[i] = p.id
+		}
+		return hosts
+	}
+
+	nodes, err := ct.ring.Lookup(ct.cc.NumSuccessors, hsh)
+	if err != nil {
+		ct.log.Error("chord lookup failed", "hash", fmt.Sprintf("%x", hsh), "err", err)
+		return nil
+	}
+	return VnodeSlice(nodes).UniqueHosts()
+}
+
 func (ct *ChordTransport) listen() {
 
 	for {
 		conn, err := ct.sock.Accept()
 		if err != nil {
-			log.Println("ERR", err)
+			ct.log.Error("accept failed", "err", err)
 			continue
 		}
 
@@ -270,10 +762,22 @@ func (ct *ChordTransport) handleConn(conn net.Conn) {
 		ct.ilock.Lock()
 		delete(ct.inbound, conn)
 		ct.ilock.Unlock()
+		ct.removePeer(conn)
 		conn.Close()
 	}()
 
-	enc := bencode.NewEncoder(conn)
+	p, err := ct.handshake(conn)
+	if err != nil {
+		ct.log.Warn("handshake failed", "peer", conn.RemoteAddr(), "err", err)
+		return
+	}
+
+	// Responses go out via p.sendMsg, not a shared bencode.Encoder: p's
+	// broadcast writeLoop writes to the same connection, and a struct encoded
+	// directly onto it can be split across many underlying Write calls, any
+	// of which writeLoop could interleave with.  sendMsg encodes the whole
+	// response into a buffer first so it reaches the wire as one atomic
+	// write.
 	dec := bencode.NewDecoder(conn)
 
 	for {
@@ -281,7 +785,7 @@ func (ct *ChordTransport) handleConn(conn net.Conn) {
 		var header bcHeader
 		err := dec.Decode(&header)
 		if err != nil {
-			log.Println("WRN", err)
+			ct.log.Warn("decode header failed", "peer", conn.RemoteAddr(), "err", err)
 			return
 		}
 
@@ -298,35 +802,91 @@ func (ct *ChordTransport) handleConn(conn net.Conn) {
 				b = &Block{}
 			}
 
-			err = enc.Encode(b)
+			err = p.sendMsg(b)
 
 		case reqTypeLastBlock:
 			blk := ct.store.LastBlock()
-			err = enc.Encode(blk)
+			err = p.sendMsg(blk)
 
 		case reqTypeFirstBlock:
 			blk := ct.store.FirstBlock()
-			err = enc.Encode(blk)
+			err = p.sendMsg(blk)
 
 		case reqTypeTxBroadcast:
 			var tx Tx
 			if err = dec.Decode(&tx); err == nil {
+				p.MarkTx(tx.Hash())
 				ct.tch <- &tx
 			}
 
 		case reqTypeBlockBroadcast:
 			var blk Block
 			if err = dec.Decode(&blk); err == nil {
+				p.MarkBlock(blk.Hash())
 				ct.bch <- blk
 			}
 
+		case reqTypeBlockAnnounce:
+			var ann blockAnnounce
+			if err = dec.Decode(&ann); err == nil {
+				p.UpdateHeight(ann.Height)
+				if b := ct.store.Get(ann.Hash); b == nil {
+					p.MarkBlock(ann.Hash)
+					ct.fetch.Announce(p.id, ann.Hash)
+				}
+			}
+
+		case reqTypeGetBlockBodies:
+			var req getBlockBodiesReq
+			if err = dec.Decode(&req); err == nil {
+				bodies := blockBodies{Blocks: make([]Block, 0, len(req.Hashes))}
+				for _, h := range req.Hashes {
+					if b := ct.store.Get(h); b != nil {
+						bodies.Blocks = append(bodies.Blocks, *b)
+					}
+				}
+				err = p.sendMsg(&bodies)
+			}
+
+		case reqTypeGetHeader:
+			var h []byte
+			if err = dec.Decode(&h); err != nil {
+				break
+			}
+
+			var hdr *BlockHeader
+			if hs, ok := ct.store.(headerStore); ok {
+				hdr = hs.GetHeader(h)
+			}
+			if hdr == nil {
+				hdr = &BlockHeader{}
+			}
+			err = p.sendMsg(hdr)
+
+		case reqTypeGetTxProof:
+			var req txProofReq
+			if err = dec.Decode(&req); err != nil {
+				break
+			}
+
+			var proof *MerkleProof
+			if ps, ok := ct.store.(txProofStore); ok {
+				proof, err = ps.TxProof(req.BlockHash, req.TxHash)
+			}
+			if err == nil {
+				if proof == nil {
+					proof = &MerkleProof{}
+				}
+				err = p.sendMsg(proof)
+			}
+
 		default:
 			err = fmt.Errorf("unknown request type: %d", header.T)
 		}
 
 		if err != nil {
 			if err != io.EOF {
-				log.Printf("ERR %v", err)
+				ct.log.Error("handle request failed", "peer", conn.RemoteAddr(), "type", header.T, "reqID", header.ReqID, "err", err)
 			}
 
 			// exit out of loop
@@ -357,6 +917,13 @@ func (ct *ChordTransport) Shutdown() {
 	}
 	ct.outbound = nil
 	ct.olock.Unlock()
+
+	ct.plock.Lock()
+	for conn, p := range ct.connPeers {
+		ct.peerSet.RemovePeer(p)
+		delete(ct.connPeers, conn)
+	}
+	ct.plock.Unlock()
 }
 
 // VnodeSlice allows operations against a set of vnodes
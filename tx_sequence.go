@@ -0,0 +1,17 @@
+package blockchain
+
+// Origin returns the sender's public key, satisfying txOrigin so TxPool can
+// track and rate-limit transactions per sender.
+func (tr *Transaction) Origin() []byte {
+	return tr.Sender
+}
+
+// Sequence returns the sender-assigned sequence number carried in Seq,
+// satisfying txSequenced so TxPool can detect gaps and hold a transaction
+// in queued until its predecessor arrives.  Seq is a dedicated, sender-
+// incremented counter distinct from Nonce, which is mined into the
+// transaction for proof-of-work/replay purposes and never increases
+// monotonically.
+func (tr *Transaction) Sequence() uint32 {
+	return tr.Seq
+}
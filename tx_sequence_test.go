@@ -0,0 +1,22 @@
+package blockchain
+
+import "testing"
+
+// TestTransactionOriginAndSequence checks that Transaction's Origin/Sequence
+// methods - the hooks TxPool's rate limiting and gap-queuing depend on -
+// read back the Sender/Seq fields set on a real transaction, rather than
+// being satisfied only by a pool-internal test double.
+func TestTransactionOriginAndSequence(t *testing.T) {
+	tr := &Transaction{
+		Signature: []byte("sig"),
+		Sender:    []byte("alice"),
+		Seq:       3,
+	}
+
+	if got := string(tr.Origin()); got != "alice" {
+		t.Fatalf("Origin() = %q, want %q", got, "alice")
+	}
+	if got := tr.Sequence(); got != 3 {
+		t.Fatalf("Sequence() = %d, want 3", got)
+	}
+}
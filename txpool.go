@@ -0,0 +1,281 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// defaultTxPoolSize bounds how many transactions TxPool holds pending
+	// before it starts evicting the lowest-priority entry to make room.
+	defaultTxPoolSize = 5000
+
+	// seenCacheSize bounds how many recently seen transaction signatures
+	// TxPool remembers, so Add can cheaply reject gossip duplicates.  The
+	// cache is only consulted inside Add; Blockchain.Run verifies each
+	// transaction before calling Add, so duplicates are still verified.
+	seenCacheSize = 20000
+
+	// maxTxsPerOrigin bounds how many transactions (pending or queued) a
+	// single origin may have in the pool at once, so one noisy or malicious
+	// sender can't flood the pool and evict every other origin's
+	// transactions.
+	maxTxsPerOrigin = 64
+
+	// maxQueuedSize bounds the total number of sequence-gapped transactions
+	// held in queued across every origin, so enough distinct origins each
+	// holding one gapped tx can't grow the pool's memory without limit.
+	maxQueuedSize = 5000
+)
+
+// txOrigin is optionally implemented by Transaction to expose the sender's
+// public key, used to track a per-origin sequence number.
+type txOrigin interface {
+	Origin() []byte
+}
+
+// txSequenced is optionally implemented by Transaction to expose a
+// sender-assigned sequence number, used to detect gaps and hold queued
+// transactions behind their predecessor.
+type txSequenced interface {
+	Sequence() uint32
+}
+
+func txKey(tr *Transaction) string {
+	return string(tr.Signature)
+}
+
+func txOriginKey(tr *Transaction) string {
+	if o, ok := interface{}(tr).(txOrigin); ok {
+		return string(o.Origin())
+	}
+	return ""
+}
+
+func txSequence(tr *Transaction) (uint32, bool) {
+	if s, ok := interface{}(tr).(txSequenced); ok {
+		return s.Sequence(), true
+	}
+	return 0, false
+}
+
+// originState tracks the sequence number of the most recently accepted
+// transaction for a single origin; the next expected one is nextSeq+1.
+type originState struct {
+	nextSeq uint32
+}
+
+// TxPool holds transactions waiting to be included in a block.
+// Immediately includable transactions sit in pending; transactions whose
+// predecessor sequence number hasn't arrived yet sit in queued until the
+// gap is filled.  A bounded set of recently seen signatures lets Add reject
+// gossiped duplicates cheaply; it does not spare callers that verify a
+// transaction before calling Add from verifying the duplicate.
+type TxPool struct {
+	mu      sync.Mutex
+	maxSize int
+
+	pending map[string]*Transaction
+	queued  map[string]*Transaction
+
+	origins map[string]*originState
+
+	seen *lruHashSet
+}
+
+// NewTxPool creates a TxPool that holds at most maxSize pending
+// transactions at a time.
+func NewTxPool(maxSize int) *TxPool {
+	if maxSize <= 0 {
+		maxSize = defaultTxPoolSize
+	}
+	return &TxPool{
+		maxSize: maxSize,
+		pending: map[string]*Transaction{},
+		queued:  map[string]*Transaction{},
+		origins: map[string]*originState{},
+		seen:    newLRUHashSet(seenCacheSize),
+	}
+}
+
+// Add validates and inserts tx into the pool.  A transaction already in the
+// seen cache is rejected outright as a duplicate, and an origin that
+// already has maxTxsPerOrigin transactions pending or queued is rejected
+// rather than allowed to evict other origins' transactions.  If tx's origin
+// has a gap before it (a predecessor sequence number hasn't arrived yet),
+// it is held in queued until the gap is closed, by a later Add or by
+// Promote, which move it into pending.  Otherwise it goes
+// straight into pending, evicting the lowest-priority pending transaction
+// first if the pool is already at capacity.
+func (p *TxPool) Add(tx *Transaction) error {
+	key := txKey(tx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.seen.Has([]byte(key)) {
+		return fmt.Errorf("duplicate transaction")
+	}
+
+	origin := txOriginKey(tx)
+	seq, hasSeq := txSequence(tx)
+
+	if origin != "" && p.originCount(origin) >= maxTxsPerOrigin {
+		return fmt.Errorf("origin %x exceeded rate limit of %d pool transactions", origin, maxTxsPerOrigin)
+	}
+
+	p.seen.Add([]byte(key))
+
+	if hasSeq && origin != "" {
+		if st, ok := p.origins[origin]; ok && seq > st.nextSeq+1 {
+			if len(p.queued) >= maxQueuedSize {
+				return fmt.Errorf("tx pool queued capacity of %d exceeded", maxQueuedSize)
+			}
+			p.queued[key] = tx
+			return nil
+		}
+	}
+
+	if len(p.pending) >= p.maxSize {
+		p.evictLowestPriority()
+	}
+	p.pending[key] = tx
+
+	if hasSeq && origin != "" {
+		p.origins[origin] = &originState{nextSeq: seq}
+		p.promoteLocked(origin)
+	}
+
+	return nil
+}
+
+// originCount returns how many transactions, pending or queued, belong to
+// origin.  Callers must hold p.mu.
+func (p *TxPool) originCount(origin string) int {
+	n := 0
+	for _, tr := range p.pending {
+		if txOriginKey(tr) == origin {
+			n++
+		}
+	}
+	for _, tr := range p.queued {
+		if txOriginKey(tr) == origin {
+			n++
+		}
+	}
+	return n
+}
+
+// evictLowestPriority drops the pending transaction with the newest
+// timestamp - the lowest-priority entry, since Pending sorts oldest
+// (highest priority) first - to make room for a new one.
+func (p *TxPool) evictLowestPriority() {
+	var newestKey string
+	var newest *Transaction
+
+	for k, tr := range p.pending {
+		if newest == nil || tr.Timestamp > newest.Timestamp {
+			newest, newestKey = tr, k
+		}
+	}
+	if newest != nil {
+		delete(p.pending, newestKey)
+	}
+}
+
+// Pending returns every immediately includable transaction, highest
+// priority (oldest) first.
+func (p *TxPool) Pending() []*Transaction {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	out := make([]*Transaction, 0, len(p.pending))
+	for _, tr := range p.pending {
+		out = append(out, tr)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Timestamp < out[j].Timestamp })
+	return out
+}
+
+// Remove drops the transactions with the given signatures from the pool.
+func (p *TxPool) Remove(sigs [][]byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, sig := range sigs {
+		delete(p.pending, string(sig))
+		delete(p.queued, string(sig))
+	}
+}
+
+// Promote is called whenever a new block lands: it drops any pending or
+// queued transactions the block already included, then promotes any queued
+// transaction whose gap that closed.
+func (p *TxPool) Promote(included TransactionSlice) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, tr := range included {
+		t := tr
+		key := txKey(&t)
+		delete(p.pending, key)
+		delete(p.queued, key)
+
+		// an included tx advances its origin's sequence, closing any gap
+		// queued transactions were waiting on
+		origin := txOriginKey(&t)
+		if seq, ok := txSequence(&t); ok && origin != "" {
+			if st, ok := p.origins[origin]; ok && seq > st.nextSeq {
+				p.origins[origin] = &originState{nextSeq: seq}
+			}
+		}
+	}
+
+	for origin := range p.origins {
+		p.promoteLocked(origin)
+	}
+}
+
+// promoteLocked moves queued transactions for origin into pending as long
+// as each one closes the next expected sequence gap.  Callers must hold
+// p.mu.
+func (p *TxPool) promoteLocked(origin string) {
+	for {
+		st, ok := p.origins[origin]
+		if !ok {
+			return
+		}
+
+		moved := false
+		for key, tr := range p.queued {
+			if txOriginKey(tr) != origin {
+				continue
+			}
+			seq, ok := txSequence(tr)
+			if !ok || seq != st.nextSeq+1 {
+				continue
+			}
+
+			delete(p.queued, key)
+			if len(p.pending) >= p.maxSize {
+				p.evictLowestPriority()
+			}
+			p.pending[key] = tr
+			p.origins[origin] = &originState{nextSeq: seq}
+			moved = true
+			break
+		}
+
+		if !moved {
+			return
+		}
+	}
+}
+
+// Len returns the number of pending transactions.
+func (p *TxPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending)
+}
@@ -0,0 +1,199 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+)
+
+// newTestTx builds a Transaction for TxPool tests.  origin and seq are
+// carried on Sender/Seq, which Transaction exposes through the
+// txOrigin/txSequenced interfaces as Origin()/Sequence().
+func newTestTx(sig string, ts uint32, origin string, seq uint32) *Transaction {
+	return &Transaction{
+		Signature: []byte(sig),
+		Timestamp: ts,
+		Sender:    []byte(origin),
+		Seq:       seq,
+	}
+}
+
+func TestTxPoolEvictsNewestOnOverflow(t *testing.T) {
+	pool := NewTxPool(3)
+
+	for i, sig := range []string{"a", "b", "c"} {
+		tx := &Transaction{Signature: []byte(sig), Timestamp: uint32(i + 1)}
+		if err := pool.Add(tx); err != nil {
+			t.Fatalf("add %s: %v", sig, err)
+		}
+	}
+
+	// d arrives once the pool is already at capacity and is newer than
+	// everything pending, so it should evict the newest (lowest-priority)
+	// entry rather than the oldest.
+	if err := pool.Add(&Transaction{Signature: []byte("d"), Timestamp: 10}); err != nil {
+		t.Fatalf("add d: %v", err)
+	}
+
+	if n := pool.Len(); n != 3 {
+		t.Fatalf("pool len = %d, want 3", n)
+	}
+
+	present := map[string]bool{}
+	for _, tr := range pool.Pending() {
+		present[string(tr.Signature)] = true
+	}
+	if present["c"] {
+		t.Fatalf("evictLowestPriority evicted the oldest entry instead of the newest")
+	}
+	if !present["d"] {
+		t.Fatalf("newly added transaction was evicted instead of an existing one")
+	}
+}
+
+func TestTxPoolRejectsDuplicates(t *testing.T) {
+	pool := NewTxPool(10)
+	tx := &Transaction{Signature: []byte("dup"), Timestamp: 1}
+
+	if err := pool.Add(tx); err != nil {
+		t.Fatalf("first add: %v", err)
+	}
+	if err := pool.Add(tx); err == nil {
+		t.Fatalf("expected duplicate transaction to be rejected")
+	}
+	if pool.Len() != 1 {
+		t.Fatalf("pool len = %d, want 1", pool.Len())
+	}
+}
+
+func TestTxPoolHoldsSequenceGapInQueued(t *testing.T) {
+	pool := NewTxPool(10)
+
+	// alice's first transaction establishes her sequence baseline.
+	if err := pool.Add(newTestTx("alice-1", 1, "alice", 1)); err != nil {
+		t.Fatalf("add alice-1: %v", err)
+	}
+
+	// alice-3 skips over seq 2, so it must be held in queued rather than
+	// made immediately includable.
+	alice3 := newTestTx("alice-3", 3, "alice", 3)
+	if err := pool.Add(alice3); err != nil {
+		t.Fatalf("add alice-3: %v", err)
+	}
+
+	if pool.Len() != 1 {
+		t.Fatalf("pool len = %d, want 1 (alice-3 should be queued, not pending)", pool.Len())
+	}
+	if _, ok := pool.queued[txKey(alice3)]; !ok {
+		t.Fatalf("alice-3 should be held in queued behind the sequence gap")
+	}
+
+	// alice-2 closes the gap, which should promote alice-3 straight away.
+	if err := pool.Add(newTestTx("alice-2", 2, "alice", 2)); err != nil {
+		t.Fatalf("add alice-2: %v", err)
+	}
+
+	if pool.Len() != 3 {
+		t.Fatalf("pool len = %d, want 3 after the gap closed", pool.Len())
+	}
+	if len(pool.queued) != 0 {
+		t.Fatalf("expected queued to be empty once alice-3 was promoted, got %d entries", len(pool.queued))
+	}
+}
+
+func TestTxPoolOriginRateLimiting(t *testing.T) {
+	pool := NewTxPool(maxTxsPerOrigin + 10)
+
+	for i := uint32(1); i <= maxTxsPerOrigin; i++ {
+		sig := fmt.Sprintf("bob-%d", i)
+		if err := pool.Add(newTestTx(sig, i, "bob", i)); err != nil {
+			t.Fatalf("add bob tx %d: %v", i, err)
+		}
+	}
+
+	if err := pool.Add(newTestTx("bob-overflow", maxTxsPerOrigin+1, "bob", maxTxsPerOrigin+1)); err == nil {
+		t.Fatalf("expected origin %q to be rejected once it exceeded maxTxsPerOrigin", "bob")
+	}
+
+	// a different origin is unaffected by bob's limit.
+	if err := pool.Add(newTestTx("carol-1", 1, "carol", 1)); err != nil {
+		t.Fatalf("add carol-1: %v", err)
+	}
+}
+
+func TestTxPoolQueuedSizeBounded(t *testing.T) {
+	pool := NewTxPool(maxQueuedSize + 10)
+
+	// Each origin establishes a baseline in pending then submits a gapped
+	// follow-up, so every follow-up lands in queued rather than pending,
+	// regardless of origin rate limiting (each origin only ever holds 2 txs
+	// here).
+	for i := 0; i < maxQueuedSize; i++ {
+		origin := fmt.Sprintf("origin-%d", i)
+		if err := pool.Add(newTestTx(origin+"-1", 1, origin, 1)); err != nil {
+			t.Fatalf("add %s-1: %v", origin, err)
+		}
+		if err := pool.Add(newTestTx(origin+"-3", 3, origin, 3)); err != nil {
+			t.Fatalf("add %s-3: %v", origin, err)
+		}
+	}
+
+	if n := len(pool.queued); n != maxQueuedSize {
+		t.Fatalf("queued len = %d, want %d", n, maxQueuedSize)
+	}
+
+	// A fresh origin's first tx is its baseline, so establish one before the
+	// gapped follow-up that must be refused a place in queued.
+	if err := pool.Add(newTestTx("overflow-1", 1, "overflow", 1)); err != nil {
+		t.Fatalf("add overflow-1: %v", err)
+	}
+	if err := pool.Add(newTestTx("overflow-3", 3, "overflow", 3)); err == nil {
+		t.Fatalf("expected queued insert to be rejected once maxQueuedSize was reached")
+	}
+}
+
+func TestTxPoolOriginRateLimitAppliesWithoutSequenceGap(t *testing.T) {
+	pool := NewTxPool(maxTxsPerOrigin + 10)
+
+	// None of these establish a sequence gap (each seq is exactly the
+	// origin's next expected one), so every tx lands straight in pending;
+	// the per-origin cap must still apply there.
+	for i := uint32(1); i <= maxTxsPerOrigin; i++ {
+		sig := fmt.Sprintf("dave-%d", i)
+		if err := pool.Add(newTestTx(sig, i, "dave", i)); err != nil {
+			t.Fatalf("add dave tx %d: %v", i, err)
+		}
+	}
+
+	if err := pool.Add(newTestTx("dave-overflow", maxTxsPerOrigin+1, "dave", maxTxsPerOrigin+1)); err == nil {
+		t.Fatalf("expected origin %q to be rejected once it exceeded maxTxsPerOrigin in pending alone", "dave")
+	}
+}
+
+func TestTxPoolPromoteDropsIncludedAndPromotesClosedGap(t *testing.T) {
+	pool := NewTxPool(10)
+
+	if err := pool.Add(newTestTx("alice-1", 1, "alice", 1)); err != nil {
+		t.Fatalf("add alice-1: %v", err)
+	}
+
+	alice3 := newTestTx("alice-3", 3, "alice", 3)
+	if err := pool.Add(alice3); err != nil {
+		t.Fatalf("add alice-3: %v", err)
+	}
+
+	// alice-2 closes the gap but is only ever seen inside a mined block,
+	// never passing through Add.
+	alice2 := newTestTx("alice-2", 2, "alice", 2)
+
+	pool.Promote(TransactionSlice{*alice2})
+
+	if _, ok := pool.pending[txKey(alice2)]; ok {
+		t.Fatalf("transaction included in the block should be dropped from pending")
+	}
+	if _, ok := pool.pending[txKey(alice3)]; !ok {
+		t.Fatalf("alice-3 should be promoted to pending once alice's gap closed")
+	}
+	if _, ok := pool.queued[txKey(alice3)]; ok {
+		t.Fatalf("alice-3 should no longer be queued")
+	}
+}